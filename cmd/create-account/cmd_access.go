@@ -0,0 +1,93 @@
+// Copyright 2022 The Matrix.org Foundation C.I.C.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+
+	"github.com/matrix-org/dendrite/setup/config"
+)
+
+// accessUsage is printed when 'create-account access' is invoked with no
+// recognised subcommand.
+//
+// Neither subcommand is backed by anything in userapi/storage today: appservices
+// are configured entirely through their registration YAML and Dendrite has no
+// per-room admin account type, so there is no per-account flag to flip. Both
+// are kept as a discoverable, clearly-failing stub rather than removed outright,
+// in case a future admin API grows a real equivalent.
+const accessUsage = `Usage: %s access <grant|revoke> [arguments]
+
+Subcommands:
+  grant   Grant an account appservice or room-admin access (not currently supported)
+  revoke  Revoke a previously granted role from an account (not currently supported)
+
+`
+
+// dispatchAccess handles every 'create-account access <...>' invocation.
+func dispatchAccess(cfg *config.Dendrite, args []string) {
+	if len(args) == 0 {
+		_, _ = fmt.Fprintf(os.Stderr, accessUsage, os.Args[0])
+		os.Exit(1)
+	}
+
+	switch args[0] {
+	case "grant":
+		accessChangeRole(cfg, args[1:], true)
+	case "revoke":
+		accessChangeRole(cfg, args[1:], false)
+	default:
+		_, _ = fmt.Fprintf(os.Stderr, accessUsage, os.Args[0])
+		os.Exit(1)
+	}
+}
+
+// accessChangeRole implements both 'access grant' and 'access revoke'. Both
+// currently fail with a clear error: Dendrite has no per-account appservice
+// or room-admin access flag in userapi/storage, nor an admin HTTP endpoint
+// that grants one, so there is nothing for this subcommand to route to yet.
+func accessChangeRole(cfg *config.Dendrite, args []string, grant bool) {
+	name := "access revoke"
+	if grant {
+		name = "access grant"
+	}
+	fs := flag.NewFlagSet(name, flag.ExitOnError)
+	username := fs.String("username", "", "The username of the account to change")
+	role := fs.String("role", "", "The role to change, one of 'appservice' or 'room-admin'")
+	format := addFormatFlag(fs)
+	_ = fs.Parse(args)
+
+	checkUsername(*username, cfg)
+
+	switch *role {
+	case "appservice", "room-admin":
+	default:
+		printResult(outputFormat(*format), result{Username: *username, Status: "failed", Error: "role must be one of 'appservice' or 'room-admin'"})
+		os.Exit(1)
+	}
+
+	action := "revoke"
+	if grant {
+		action = "grant"
+	}
+	printResult(outputFormat(*format), result{
+		Username: *username,
+		Status:   "failed",
+		Error:    fmt.Sprintf("cannot %s %s access: Dendrite has no per-account flag for this in userapi/storage", action, *role),
+	})
+	os.Exit(1)
+}
@@ -0,0 +1,208 @@
+// Copyright 2022 The Matrix.org Foundation C.I.C.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+
+	"github.com/sirupsen/logrus"
+
+	"github.com/matrix-org/dendrite/setup/base"
+	"github.com/matrix-org/dendrite/setup/config"
+)
+
+// userUsage is printed when 'create-account user' is invoked with no
+// recognised subcommand.
+const userUsage = `Usage: %s user <add|reset-password|deactivate|list|set-admin> [arguments]
+
+Subcommands:
+  add             Register a new account
+  reset-password  Reset the password of an existing account
+  deactivate      Deactivate an existing account
+  list            List accounts known to the homeserver (not currently supported)
+  set-admin       Grant or revoke server admin rights for an account (not currently supported)
+
+`
+
+// dispatchUser handles every 'create-account user <...>' invocation. cfg has
+// already been parsed from the global flags (e.g. --config) by main.
+func dispatchUser(cfg *config.Dendrite, args []string) {
+	if len(args) == 0 {
+		_, _ = fmt.Fprintf(os.Stderr, userUsage, os.Args[0])
+		os.Exit(1)
+	}
+
+	switch args[0] {
+	case "add":
+		userAdd(cfg, args[1:])
+	case "reset-password":
+		userResetPassword(cfg, args[1:])
+	case "deactivate":
+		userDeactivate(cfg, args[1:])
+	case "list":
+		userList(cfg, args[1:])
+	case "set-admin":
+		userSetAdmin(cfg, args[1:])
+	default:
+		_, _ = fmt.Fprintf(os.Stderr, userUsage, os.Args[0])
+		os.Exit(1)
+	}
+}
+
+func userAdd(cfg *config.Dendrite, args []string) {
+	fs := flag.NewFlagSet("user add", flag.ExitOnError)
+	username := fs.String("username", "", "The username of the account to register (specify the localpart only, e.g. 'alice' for '@alice:domain.com')")
+	password := fs.String("password", "", "The password to associate with the account")
+	pwdFile := fs.String("passwordfile", "", "The file to use for the password (e.g. for automated account creation)")
+	pwdStdin := fs.Bool("passwordstdin", false, "Reads the password from stdin")
+	isAdmin := fs.Bool("admin", false, "Create an admin account")
+	serverURL := fs.String("url", "https://localhost:8448", "The URL to connect to.")
+	fromFile := fs.String("from-file", "", "Provision many accounts from a YAML or CSV manifest instead of a single --username/--password pair")
+	update := fs.Bool("update", false, "With --from-file, update accounts that already exist instead of skipping them")
+	secretsFile := fs.String("secrets-file", "", "With --from-file, append generated passwords to this file instead of printing them")
+	format := addFormatFlag(fs)
+	_ = fs.Parse(args)
+
+	if *fromFile != "" {
+		ok := provisionFromManifest(cfg, provisionOptions{
+			manifestPath: *fromFile,
+			update:       *update,
+			secretsFile:  *secretsFile,
+			serverURL:    *serverURL,
+			format:       outputFormat(*format),
+		})
+		if !ok {
+			os.Exit(1)
+		}
+		return
+	}
+
+	checkUsername(*username, cfg)
+
+	pass, err := getPassword(*password, *pwdFile, *pwdStdin, os.Stdin)
+	if err != nil {
+		logrus.Fatalln(err)
+	}
+
+	accessToken, err := sharedSecretRegister(cfg.ClientAPI.RegistrationSharedSecret, *serverURL, *username, pass, *isAdmin)
+	if err != nil {
+		printResult(outputFormat(*format), result{Username: *username, Status: "failed", Error: err.Error()})
+		os.Exit(1)
+	}
+
+	printResult(outputFormat(*format), result{
+		Username: *username,
+		Status:   "created",
+		Extra:    map[string]interface{}{"access_token": accessToken},
+	})
+}
+
+func userResetPassword(cfg *config.Dendrite, args []string) {
+	fs := flag.NewFlagSet("user reset-password", flag.ExitOnError)
+	username := fs.String("username", "", "The username of the account to reset")
+	password := fs.String("password", "", "The new password to associate with the account")
+	pwdFile := fs.String("passwordfile", "", "The file to use for the password")
+	pwdStdin := fs.Bool("passwordstdin", false, "Reads the password from stdin")
+	format := addFormatFlag(fs)
+	_ = fs.Parse(args)
+
+	checkUsername(*username, cfg)
+
+	pass, err := getPassword(*password, *pwdFile, *pwdStdin, os.Stdin)
+	if err != nil {
+		logrus.Fatalln(err)
+	}
+
+	b := base.NewBaseDendrite(cfg, "")
+	defer b.Close() // nolint: errcheck
+	accountDB := openDatabase(b, cfg)
+
+	available, err := accountDB.CheckAccountAvailability(context.Background(), *username)
+	if err != nil {
+		logrus.Fatalln("Unable check username existence.")
+	}
+	if available {
+		printResult(outputFormat(*format), result{Username: *username, Status: "failed", Error: "user does not exist"})
+		os.Exit(1)
+	}
+	if err = accountDB.SetPassword(context.Background(), *username, pass); err != nil {
+		printResult(outputFormat(*format), result{Username: *username, Status: "failed", Error: err.Error()})
+		os.Exit(1)
+	}
+	if _, err = accountDB.RemoveAllDevices(context.Background(), *username, ""); err != nil {
+		printResult(outputFormat(*format), result{Username: *username, Status: "failed", Error: err.Error()})
+		os.Exit(1)
+	}
+
+	printResult(outputFormat(*format), result{Username: *username, Status: "password-reset"})
+}
+
+func userDeactivate(cfg *config.Dendrite, args []string) {
+	fs := flag.NewFlagSet("user deactivate", flag.ExitOnError)
+	username := fs.String("username", "", "The username of the account to deactivate")
+	format := addFormatFlag(fs)
+	_ = fs.Parse(args)
+
+	checkUsername(*username, cfg)
+
+	b := base.NewBaseDendrite(cfg, "")
+	defer b.Close() // nolint: errcheck
+	accountDB := openDatabase(b, cfg)
+
+	if err := accountDB.DeactivateAccount(context.Background(), *username); err != nil {
+		printResult(outputFormat(*format), result{Username: *username, Status: "failed", Error: err.Error()})
+		os.Exit(1)
+	}
+
+	printResult(outputFormat(*format), result{Username: *username, Status: "deactivated"})
+}
+
+// userList is not currently implemented: userapi/storage only supports
+// looking an account up by localpart, it has no API to enumerate every
+// account on the homeserver, so there is nothing for this subcommand to
+// call yet.
+func userList(cfg *config.Dendrite, args []string) {
+	fs := flag.NewFlagSet("user list", flag.ExitOnError)
+	format := addFormatFlag(fs)
+	_ = fs.Parse(args)
+
+	printResult(outputFormat(*format), result{
+		Status: "failed",
+		Error:  "listing accounts is not supported: userapi/storage has no enumerate-all-accounts API",
+	})
+	os.Exit(1)
+}
+
+// userSetAdmin is not currently implemented: an account's type (admin or
+// regular user) is fixed by CreateAccount at registration time and
+// userapi/storage has no setter to change it afterwards.
+func userSetAdmin(cfg *config.Dendrite, args []string) {
+	fs := flag.NewFlagSet("user set-admin", flag.ExitOnError)
+	username := fs.String("username", "", "The username of the account to update")
+	format := addFormatFlag(fs)
+	_ = fs.Parse(args)
+
+	checkUsername(*username, cfg)
+
+	printResult(outputFormat(*format), result{
+		Username: *username,
+		Status:   "failed",
+		Error:    "changing admin status after registration is not supported: account type is fixed at creation time in userapi/storage",
+	})
+	os.Exit(1)
+}
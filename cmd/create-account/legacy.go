@@ -0,0 +1,105 @@
+// Copyright 2017 Vector Creations Ltd
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"context"
+	"flag"
+	"os"
+
+	"github.com/sirupsen/logrus"
+
+	"github.com/matrix-org/dendrite/setup/base"
+	"github.com/matrix-org/dendrite/setup/config"
+)
+
+const legacyUsage = `Usage: %s
+
+Creates a new user account on the homeserver.
+
+Example:
+
+	# provide password by parameter
+  	%s --config dendrite.yaml -username alice -password foobarbaz
+	# use password from file
+  	%s --config dendrite.yaml -username alice -passwordfile my.pass
+	# ask user to provide password
+	%s --config dendrite.yaml -username alice
+	# read password from stdin
+	%s --config dendrite.yaml -username alice -passwordstdin < my.pass
+	cat my.pass | %s --config dendrite.yaml -username alice -passwordstdin
+	# reset password for a user, can be used with a combination above to read the password
+	%s --config dendrite.yaml -reset-password -username alice -password foobarbaz
+
+DEPRECATED: this flag-based invocation is kept for backwards compatibility.
+Prefer the subcommand form instead, e.g. '%s user add -username alice'.
+Run '%s user' or '%s access' with no further arguments to list their subcommands.
+
+Arguments:
+
+`
+
+var (
+	legacyUsername      = flag.String("username", "", "The username of the account to register (specify the localpart only, e.g. 'alice' for '@alice:domain.com')")
+	legacyPassword      = flag.String("password", "", "The password to associate with the account")
+	legacyPwdFile       = flag.String("passwordfile", "", "The file to use for the password (e.g. for automated account creation)")
+	legacyPwdStdin      = flag.Bool("passwordstdin", false, "Reads the password from stdin")
+	legacyIsAdmin       = flag.Bool("admin", false, "Create an admin account")
+	legacyResetPassword = flag.Bool("reset-password", false, "Resets the password for the given username")
+	legacyServerURL     = flag.String("url", "https://localhost:8448", "The URL to connect to.")
+)
+
+// legacyMain reproduces the pre-subcommand create-account behaviour for
+// operators who still invoke the tool with the original flag syntax. It is
+// only reached when the first remaining argument isn't a known subcommand.
+// cfg has already been parsed from the global flags by main.
+func legacyMain(cfg *config.Dendrite) {
+	checkUsername(*legacyUsername, cfg)
+
+	pass, err := getPassword(*legacyPassword, *legacyPwdFile, *legacyPwdStdin, os.Stdin)
+	if err != nil {
+		logrus.Fatalln(err)
+	}
+
+	if *legacyResetPassword {
+		b := base.NewBaseDendrite(cfg, "")
+		defer b.Close() // nolint: errcheck
+		accountDB := openDatabase(b, cfg)
+
+		available, err := accountDB.CheckAccountAvailability(context.Background(), *legacyUsername)
+		if err != nil {
+			logrus.Fatalln("Unable check username existence.")
+		}
+		if available {
+			logrus.Fatalln("Username could not be found.")
+		}
+		err = accountDB.SetPassword(context.Background(), *legacyUsername, pass)
+		if err != nil {
+			logrus.Fatalf("Failed to update password for user %s: %s", *legacyUsername, err.Error())
+		}
+		if _, err = accountDB.RemoveAllDevices(context.Background(), *legacyUsername, ""); err != nil {
+			logrus.Fatalf("Failed to remove all devices: %s", err.Error())
+		}
+		logrus.Infof("Updated password for user %s and invalidated all logins\n", *legacyUsername)
+		return
+	}
+
+	accessToken, err := sharedSecretRegister(cfg.ClientAPI.RegistrationSharedSecret, *legacyServerURL, *legacyUsername, pass, *legacyIsAdmin)
+	if err != nil {
+		logrus.Fatalln("Failed to create the account:", err.Error())
+	}
+
+	logrus.Infof("Created account: %s (AccessToken: %s)", *legacyUsername, accessToken)
+}
@@ -0,0 +1,142 @@
+// Copyright 2022 The Matrix.org Foundation C.I.C.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"encoding/csv"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"gopkg.in/yaml.v2"
+)
+
+// threepid is a single third-party identifier to pre-bind to a provisioned
+// account, e.g. an email address or phone number.
+type threepid struct {
+	Medium  string `yaml:"medium" json:"medium"`
+	Address string `yaml:"address" json:"address"`
+}
+
+// manifestEntry is a single row of a --from-file provisioning manifest.
+// Admin is a pointer so that an omitted field is distinguishable from an
+// explicit 'admin: false'. On creation it picks the account type; on --update
+// an existing account's admin status is never changed, and a row that sets
+// Admin is only reported back as ignored.
+type manifestEntry struct {
+	Username    string     `yaml:"username"`
+	Password    string     `yaml:"password"`
+	Admin       *bool      `yaml:"admin"`
+	DisplayName string     `yaml:"display_name"`
+	AvatarURL   string     `yaml:"avatar_url"`
+	Threepids   []threepid `yaml:"threepids"`
+}
+
+// manifestFile is the top-level shape of a YAML provisioning manifest.
+type manifestFile struct {
+	Users []manifestEntry `yaml:"users"`
+}
+
+// loadManifest reads a provisioning manifest from path, dispatching on its
+// file extension between the YAML and CSV formats.
+func loadManifest(path string) ([]manifestEntry, error) {
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".yaml", ".yml":
+		return loadManifestYAML(path)
+	case ".csv":
+		return loadManifestCSV(path)
+	default:
+		return nil, fmt.Errorf("unsupported manifest extension %q, expected .yaml, .yml or .csv", filepath.Ext(path))
+	}
+}
+
+func loadManifestYAML(path string) ([]manifestEntry, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("unable to read manifest: %w", err)
+	}
+	var mf manifestFile
+	if err = yaml.Unmarshal(data, &mf); err != nil {
+		return nil, fmt.Errorf("unable to parse manifest as YAML: %w", err)
+	}
+	return mf.Users, nil
+}
+
+// loadManifestCSV reads a manifest where the first row is a header naming
+// the manifestEntry fields, e.g.:
+//
+//	username,password,admin,display_name,avatar_url,threepids
+//	alice,,false,Alice,,email:alice@example.com
+//
+// threepids is a single field holding ';'-separated "medium:address" pairs.
+func loadManifestCSV(path string) ([]manifestEntry, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("unable to read manifest: %w", err)
+	}
+	defer f.Close() // nolint: errcheck
+
+	r := csv.NewReader(f)
+	rows, err := r.ReadAll()
+	if err != nil {
+		return nil, fmt.Errorf("unable to parse manifest as CSV: %w", err)
+	}
+	if len(rows) == 0 {
+		return nil, nil
+	}
+
+	header := rows[0]
+	col := make(map[string]int, len(header))
+	for i, name := range header {
+		col[strings.TrimSpace(name)] = i
+	}
+
+	entries := make([]manifestEntry, 0, len(rows)-1)
+	for _, row := range rows[1:] {
+		entry := manifestEntry{}
+		if i, ok := col["username"]; ok && i < len(row) {
+			entry.Username = row[i]
+		}
+		if i, ok := col["password"]; ok && i < len(row) {
+			entry.Password = row[i]
+		}
+		if i, ok := col["admin"]; ok && i < len(row) && row[i] != "" {
+			admin, err := strconv.ParseBool(row[i])
+			if err != nil {
+				return nil, fmt.Errorf("invalid 'admin' value %q for user %q: %w", row[i], entry.Username, err)
+			}
+			entry.Admin = &admin
+		}
+		if i, ok := col["display_name"]; ok && i < len(row) {
+			entry.DisplayName = row[i]
+		}
+		if i, ok := col["avatar_url"]; ok && i < len(row) {
+			entry.AvatarURL = row[i]
+		}
+		if i, ok := col["threepids"]; ok && i < len(row) && row[i] != "" {
+			for _, pair := range strings.Split(row[i], ";") {
+				parts := strings.SplitN(pair, ":", 2)
+				if len(parts) != 2 {
+					return nil, fmt.Errorf("invalid threepid %q for user %q, expected 'medium:address'", pair, entry.Username)
+				}
+				entry.Threepids = append(entry.Threepids, threepid{Medium: parts[0], Address: parts[1]})
+			}
+		}
+		entries = append(entries, entry)
+	}
+	return entries, nil
+}
@@ -0,0 +1,239 @@
+// Copyright 2022 The Matrix.org Foundation C.I.C.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/base64"
+	"fmt"
+	"net/http"
+	"os"
+	"time"
+
+	"github.com/sirupsen/logrus"
+
+	"github.com/matrix-org/dendrite/setup/base"
+	"github.com/matrix-org/dendrite/setup/config"
+	"github.com/matrix-org/dendrite/userapi/storage"
+)
+
+// provisionOptions configures a bulk --from-file provisioning run.
+type provisionOptions struct {
+	manifestPath string
+	update       bool
+	secretsFile  string
+	serverURL    string
+	format       outputFormat
+}
+
+// provisionFromManifest loads opts.manifestPath and idempotently creates or
+// updates one account per row, sharing a single base.BaseDendrite and HTTP
+// client across the whole run. It returns true if every row succeeded
+// (created, updated or skipped) and false if at least one row hard-failed,
+// which the caller uses to decide the process exit code.
+func provisionFromManifest(cfg *config.Dendrite, opts provisionOptions) bool {
+	entries, err := loadManifest(opts.manifestPath)
+	if err != nil {
+		logrus.Fatalln(err)
+	}
+
+	if manifestHasProfileFields(entries) {
+		logrus.Warn("This manifest sets display_name, avatar_url and/or threepids, which this tool " +
+			"writes directly to the database, not through the homeserver's client API. If the target " +
+			"server is running, those accounts won't see the new values until its userapi cache for " +
+			"them is evicted or the server is restarted.")
+	}
+
+	b := base.NewBaseDendrite(cfg, "")
+	defer b.Close() // nolint: errcheck
+	accountDB := openDatabase(b, cfg)
+
+	cl := &http.Client{
+		Timeout:   time.Second * 10,
+		Transport: http.DefaultTransport,
+	}
+
+	var secrets *os.File
+	if opts.secretsFile != "" {
+		secrets, err = os.OpenFile(opts.secretsFile, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0600)
+		if err != nil {
+			logrus.Fatalf("Failed to open secrets file: %s", err.Error())
+		}
+		defer secrets.Close() // nolint: errcheck
+	}
+
+	ok := true
+	for _, entry := range entries {
+		r := provisionRow(context.Background(), accountDB, cl, cfg, opts, entry, secrets)
+		printResult(opts.format, r)
+		if r.Status == "failed" {
+			ok = false
+		}
+	}
+	return ok
+}
+
+// manifestHasProfileFields reports whether any row sets a field that is
+// applied straight to the database rather than through the homeserver's
+// client API.
+func manifestHasProfileFields(entries []manifestEntry) bool {
+	for _, entry := range entries {
+		if entry.DisplayName != "" || entry.AvatarURL != "" || len(entry.Threepids) > 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// provisionRow provisions a single manifest entry, checking for an existing
+// account first so the whole run is safe to re-run.
+func provisionRow(ctx context.Context, accountDB storage.Database, cl *http.Client, cfg *config.Dendrite, opts provisionOptions, entry manifestEntry, secrets *os.File) result {
+	if err := validateUsername(entry.Username, cfg); err != nil {
+		return result{Username: entry.Username, Status: "failed", Error: err.Error()}
+	}
+
+	available, err := accountDB.CheckAccountAvailability(ctx, entry.Username)
+	if err != nil {
+		return result{Username: entry.Username, Status: "failed", Error: fmt.Sprintf("unable to check availability: %s", err.Error())}
+	}
+
+	if !available {
+		if !opts.update {
+			return result{Username: entry.Username, Status: "skipped"}
+		}
+		return updateRow(ctx, accountDB, entry)
+	}
+
+	password := entry.Password
+	generated := false
+	if password == "" {
+		password, err = generatePassword()
+		if err != nil {
+			return result{Username: entry.Username, Status: "failed", Error: fmt.Sprintf("unable to generate password: %s", err.Error())}
+		}
+		generated = true
+	}
+
+	admin := entry.Admin != nil && *entry.Admin
+	accessToken, err := sharedSecretRegisterWithClient(cl, cfg.ClientAPI.RegistrationSharedSecret, opts.serverURL, entry.Username, password, admin)
+	if err != nil {
+		return result{Username: entry.Username, Status: "failed", Error: err.Error()}
+	}
+
+	if entry.DisplayName != "" {
+		if err = accountDB.SetDisplayName(ctx, entry.Username, entry.DisplayName); err != nil {
+			return result{Username: entry.Username, Status: "failed", Error: fmt.Sprintf("account created but failed to set display name: %s", err.Error())}
+		}
+	}
+	if entry.AvatarURL != "" {
+		if err = accountDB.SetAvatarURL(ctx, entry.Username, entry.AvatarURL); err != nil {
+			return result{Username: entry.Username, Status: "failed", Error: fmt.Sprintf("account created but failed to set avatar: %s", err.Error())}
+		}
+	}
+	for _, tp := range entry.Threepids {
+		if err = bindThreePID(ctx, accountDB, entry.Username, tp); err != nil {
+			return result{Username: entry.Username, Status: "failed", Error: fmt.Sprintf("account created but failed to bind threepid %s: %s", tp.Address, err.Error())}
+		}
+	}
+
+	extra := map[string]interface{}{"access_token": accessToken}
+	if generated {
+		if secrets != nil {
+			writeGeneratedPassword(secrets, entry.Username, password)
+		} else {
+			extra["password"] = password
+		}
+	}
+	return result{Username: entry.Username, Status: "created", Extra: extra}
+}
+
+// updateRow applies a manifest row's fields to an account that already
+// exists. entry.Admin is intentionally not applied here: an account's type
+// is fixed at creation time by CreateAccount and userapi/storage has no
+// setter to change it afterwards, so an --update row that sets admin is
+// reported back rather than silently honoured or hard-failed.
+func updateRow(ctx context.Context, accountDB storage.Database, entry manifestEntry) result {
+	if entry.Password != "" {
+		if err := accountDB.SetPassword(ctx, entry.Username, entry.Password); err != nil {
+			return result{Username: entry.Username, Status: "failed", Error: fmt.Sprintf("unable to update password: %s", err.Error())}
+		}
+		if _, err := accountDB.RemoveAllDevices(ctx, entry.Username, ""); err != nil {
+			return result{Username: entry.Username, Status: "failed", Error: fmt.Sprintf("password updated but failed to invalidate existing sessions: %s", err.Error())}
+		}
+	}
+	if entry.DisplayName != "" {
+		if err := accountDB.SetDisplayName(ctx, entry.Username, entry.DisplayName); err != nil {
+			return result{Username: entry.Username, Status: "failed", Error: fmt.Sprintf("unable to update display name: %s", err.Error())}
+		}
+	}
+	if entry.AvatarURL != "" {
+		if err := accountDB.SetAvatarURL(ctx, entry.Username, entry.AvatarURL); err != nil {
+			return result{Username: entry.Username, Status: "failed", Error: fmt.Sprintf("unable to update avatar: %s", err.Error())}
+		}
+	}
+	for _, tp := range entry.Threepids {
+		if err := bindThreePID(ctx, accountDB, entry.Username, tp); err != nil {
+			return result{Username: entry.Username, Status: "failed", Error: fmt.Sprintf("unable to bind threepid %s: %s", tp.Address, err.Error())}
+		}
+	}
+
+	var extra map[string]interface{}
+	if entry.Admin != nil {
+		extra = map[string]interface{}{"admin": "ignored: admin status cannot be changed after account creation"}
+	}
+	return result{Username: entry.Username, Status: "updated", Extra: extra}
+}
+
+// bindThreePID idempotently associates tp with localpart. SaveThreePIDAssociation
+// is an insert keyed on (medium, address), not an upsert, so re-running a manifest
+// that already bound this pid to this account would otherwise fail every time
+// after the first. If the pid is already bound to a different account, that's
+// reported back as a hard failure rather than silently re-pointing it.
+func bindThreePID(ctx context.Context, accountDB storage.Database, localpart string, tp threepid) error {
+	existing, err := accountDB.GetLocalpartForThreePID(ctx, tp.Address, tp.Medium)
+	if err != nil {
+		return fmt.Errorf("unable to check existing binding for threepid %s: %w", tp.Address, err)
+	}
+	if existing == localpart {
+		return nil
+	}
+	if existing != "" {
+		return fmt.Errorf("threepid %s is already bound to a different account (%s)", tp.Address, existing)
+	}
+	if err := accountDB.SaveThreePIDAssociation(ctx, tp.Address, localpart, tp.Medium); err != nil {
+		return fmt.Errorf("unable to bind threepid %s: %w", tp.Address, err)
+	}
+	return nil
+}
+
+// generatePassword returns a random password suitable for an auto-provisioned
+// account that wasn't given an explicit one in the manifest.
+func generatePassword() (string, error) {
+	buf := make([]byte, 18)
+	if _, err := rand.Read(buf); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(buf), nil
+}
+
+// writeGeneratedPassword appends a generated password to the sidecar secrets
+// file.
+func writeGeneratedPassword(secrets *os.File, username, password string) {
+	line := fmt.Sprintf("%s:%s\n", username, password)
+	if _, err := secrets.WriteString(line); err != nil {
+		logrus.WithError(err).Warn("Failed to write generated password to secrets file")
+	}
+}
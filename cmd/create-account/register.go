@@ -0,0 +1,174 @@
+// Copyright 2017 Vector Creations Ltd
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha1"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/tidwall/gjson"
+	"golang.org/x/term"
+)
+
+// sharedSecretRegistrationRequest is the body sent to the Synapse-compatible
+// admin registration endpoint, shared by both the legacy flow and the
+// "user add" subcommand.
+type sharedSecretRegistrationRequest struct {
+	User     string `json:"username"`
+	Password string `json:"password"`
+	Nonce    string `json:"nonce"`
+	MacStr   string `json:"mac"`
+	Admin    bool   `json:"admin"`
+}
+
+// sharedSecretRegister registers a single account through the
+// Synapse-compatible shared-secret admin endpoint, opening a short-lived
+// HTTP client for the request.
+func sharedSecretRegister(sharedSecret, serverURL, localpart, password string, admin bool) (accesToken string, err error) {
+	cl := &http.Client{
+		Timeout:   time.Second * 10,
+		Transport: http.DefaultTransport,
+	}
+	return sharedSecretRegisterWithClient(cl, sharedSecret, serverURL, localpart, password, admin)
+}
+
+// sharedSecretRegisterWithClient is the same as sharedSecretRegister but
+// reuses a caller-provided HTTP client, so that bulk provisioning doesn't pay
+// for a fresh client (and TLS handshake) on every row.
+func sharedSecretRegisterWithClient(cl *http.Client, sharedSecret, serverURL, localpart, password string, admin bool) (accesToken string, err error) {
+	registerURL := fmt.Sprintf("%s/_synapse/admin/v1/register", serverURL)
+	nonceReq, err := http.NewRequest(http.MethodGet, registerURL, nil)
+	if err != nil {
+		return "", fmt.Errorf("unable to create http request: %w", err)
+	}
+
+	nonceResp, err := cl.Do(nonceReq)
+	if err != nil {
+		return "", fmt.Errorf("unable to get nonce: %w", err)
+	}
+	body, err := ioutil.ReadAll(nonceResp.Body)
+	if err != nil {
+		return "", fmt.Errorf("failed to read response body: %w", err)
+	}
+	defer nonceResp.Body.Close() // nolint: errcheck
+
+	nonce := gjson.GetBytes(body, "nonce").Str
+
+	adminStr := "notadmin"
+	if admin {
+		adminStr = "admin"
+	}
+	reg := sharedSecretRegistrationRequest{
+		User:     localpart,
+		Password: password,
+		Nonce:    nonce,
+		Admin:    admin,
+	}
+	macStr, err := getRegisterMac(sharedSecret, nonce, localpart, password, adminStr)
+	if err != nil {
+		return "", err
+	}
+	reg.MacStr = macStr
+
+	js, err := json.Marshal(reg)
+	if err != nil {
+		return "", fmt.Errorf("unable to marshal json: %w", err)
+	}
+	registerReq, err := http.NewRequest(http.MethodPost, registerURL, bytes.NewBuffer(js))
+	if err != nil {
+		return "", fmt.Errorf("unable to create http request: %w", err)
+
+	}
+	regResp, err := cl.Do(registerReq)
+	if err != nil {
+		return "", fmt.Errorf("unable to create account: %w", err)
+	}
+	defer regResp.Body.Close() // nolint: errcheck
+	if regResp.StatusCode < 200 || regResp.StatusCode >= 300 {
+		body, _ = ioutil.ReadAll(regResp.Body)
+		return "", fmt.Errorf(gjson.GetBytes(body, "error").Str)
+	}
+	r, _ := ioutil.ReadAll(regResp.Body)
+
+	return gjson.GetBytes(r, "access_token").Str, nil
+}
+
+func getRegisterMac(sharedSecret, nonce, localpart, password, adminStr string) (string, error) {
+	joined := strings.Join([]string{nonce, localpart, password, adminStr}, "\x00")
+	mac := hmac.New(sha1.New, []byte(sharedSecret))
+	_, err := mac.Write([]byte(joined))
+	if err != nil {
+		return "", fmt.Errorf("unable to construct mac: %w", err)
+	}
+	regMac := mac.Sum(nil)
+
+	return hex.EncodeToString(regMac), nil
+}
+
+// getPassword resolves the password to use for a user from the various
+// input sources the tool supports, in order of precedence: a password file,
+// stdin, then the explicit flag, falling back to an interactive prompt when
+// the flag is empty.
+func getPassword(password, pwdFile string, pwdStdin bool, r io.Reader) (string, error) {
+	// read password from file
+	if pwdFile != "" {
+		pw, err := ioutil.ReadFile(pwdFile)
+		if err != nil {
+			return "", fmt.Errorf("Unable to read password from file: %v", err)
+		}
+		return strings.TrimSpace(string(pw)), nil
+	}
+
+	// read password from stdin
+	if pwdStdin {
+		data, err := ioutil.ReadAll(r)
+		if err != nil {
+			return "", fmt.Errorf("Unable to read password from stdin: %v", err)
+		}
+		return strings.TrimSpace(string(data)), nil
+	}
+
+	// If no parameter was set, ask the user to provide the password
+	if password == "" {
+		fmt.Print("Enter Password: ")
+		bytePassword, err := term.ReadPassword(int(os.Stdin.Fd()))
+		if err != nil {
+			return "", fmt.Errorf("Unable to read password: %v", err)
+		}
+		fmt.Println()
+		fmt.Print("Confirm Password: ")
+		bytePassword2, err := term.ReadPassword(int(os.Stdin.Fd()))
+		if err != nil {
+			return "", fmt.Errorf("Unable to read password: %v", err)
+		}
+		fmt.Println()
+		if strings.TrimSpace(string(bytePassword)) != strings.TrimSpace(string(bytePassword2)) {
+			return "", fmt.Errorf("Entered passwords don't match")
+		}
+		return strings.TrimSpace(string(bytePassword)), nil
+	}
+
+	return password, nil
+}